@@ -1,11 +1,15 @@
 package utils
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
 
 	"github.com/cosmos/cosmos-sdk/client/context"
 	"github.com/cosmos/cosmos-sdk/client/keys"
+	crkeys "github.com/cosmos/cosmos-sdk/crypto/keys"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	auth "github.com/cosmos/cosmos-sdk/x/auth"
 	authtxb "github.com/cosmos/cosmos-sdk/x/auth/client/txbuilder"
@@ -19,7 +23,7 @@ import (
 // addition, it builds and signs a transaction with the supplied messages.
 // Finally, it broadcasts the signed transaction to a node.
 func CompleteAndBroadcastTxCli(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, msgs []sdk.Msg) error {
-	txBldr, err := prepareTxBuilder(txBldr, cliCtx)
+	txBldr, err := prepareTxBuilder(txBldr, cliCtx, false)
 	if err != nil {
 		return err
 	}
@@ -108,14 +112,128 @@ func SimulateMsgs(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, name stri
 	return
 }
 
+// GasEstimatePolicy selects how a batch of simulated gas estimates is
+// reduced to a single value.
+type GasEstimatePolicy int
+
+const (
+	// GasPolicyMax picks the largest estimate in a batch.
+	GasPolicyMax GasEstimatePolicy = iota
+	// GasPolicyMin picks the smallest estimate in a batch.
+	GasPolicyMin
+	// GasPolicyPercentile picks the estimate at the percentile passed to
+	// AdjustGasEstimates.
+	GasPolicyPercentile
+)
+
+// GasEstimator memoizes gas estimates by a hash of the msgs, account
+// number, sequence and chain-id they were simulated against. The cache is
+// guarded by a mutex since it may be shared by concurrent callers (e.g. a
+// long-running REST handler simulating on behalf of several requests);
+// each key already binds an estimate to one account at one sequence, so a
+// later tx from the same account naturally misses the cache instead of
+// reusing a stale value.
+type GasEstimator struct {
+	mu    sync.Mutex
+	cache map[string]int64
+}
+
+// NewGasEstimator returns an empty GasEstimator.
+func NewGasEstimator() *GasEstimator {
+	return &GasEstimator{cache: make(map[string]int64)}
+}
+
+// Estimate returns the raw, unadjusted gas estimate for msgs under txBldr,
+// querying the node only on a cache miss.
+func (ge *GasEstimator) Estimate(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, name string, msgs []sdk.Msg) (int64, error) {
+	key := gasEstimateKey(msgs, txBldr.AccountNumber, txBldr.Sequence, txBldr.ChainID)
+
+	ge.mu.Lock()
+	estimate, ok := ge.cache[key]
+	ge.mu.Unlock()
+	if ok {
+		return estimate, nil
+	}
+
+	estimate, _, err := SimulateMsgs(txBldr, cliCtx, name, msgs, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	ge.mu.Lock()
+	ge.cache[key] = estimate
+	ge.mu.Unlock()
+	return estimate, nil
+}
+
+func gasEstimateKey(msgs []sdk.Msg, accountNumber, sequence int64, chainID string) string {
+	h := sha256.New()
+	for _, msg := range msgs {
+		h.Write(msg.GetSignBytes())
+	}
+	fmt.Fprintf(h, "|%d|%d|%s", accountNumber, sequence, chainID)
+	return string(h.Sum(nil))
+}
+
+// SimulateMsgsBatch simulates every candidate in msgSets against txBldr via
+// the existing /app/simulate query, one candidate at a time, and returns
+// the raw (unadjusted) estimate for each in order. A candidate already
+// seen by ge (same msgs, account number, sequence and chain-id) is served
+// from its cache instead of re-querying the node.
+func SimulateMsgsBatch(ge *GasEstimator, txBldr authtxb.TxBuilder, cliCtx context.CLIContext, name string, msgSets [][]sdk.Msg) (estimates []int64, err error) {
+	estimates = make([]int64, len(msgSets))
+	for i, msgs := range msgSets {
+		estimates[i], err = ge.Estimate(txBldr, cliCtx, name, msgs)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// AdjustGasEstimates reduces estimates to a single value per policy and
+// multiplies it by adjustment. pct is the percentile (0-100) used only by
+// GasPolicyPercentile.
+func AdjustGasEstimates(estimates []int64, adjustment float64, policy GasEstimatePolicy, pct float64) int64 {
+	if len(estimates) == 0 {
+		return 0
+	}
+
+	sorted := make([]int64, len(estimates))
+	copy(sorted, estimates)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var picked int64
+	switch policy {
+	case GasPolicyMin:
+		picked = sorted[0]
+	case GasPolicyPercentile:
+		idx := int(pct / 100 * float64(len(sorted)-1))
+		picked = sorted[idx]
+	default:
+		picked = sorted[len(sorted)-1]
+	}
+	return adjustGasEstimate(picked, adjustment)
+}
+
+// defaultGasEstimator backs EnrichCtxWithGas. CLIContext carries no place to
+// stash a per-command cache, so this is process-lifetime rather than
+// scoped to one CLIContext; its mutex and per-account-and-sequence cache
+// key make that safe both for concurrent callers and for a later tx from
+// the same account, which simply misses the cache instead of reusing a
+// stale estimate.
+var defaultGasEstimator = NewGasEstimator()
+
 // EnrichCtxWithGas calculates the gas estimate that would be consumed by the
-// transaction and set the transaction's respective value accordingly.
+// transaction and set the transaction's respective value accordingly. The
+// estimate is served from defaultGasEstimator's cache when this exact
+// candidate was already simulated earlier in the process.
 func EnrichCtxWithGas(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, name string, msgs []sdk.Msg) (authtxb.TxBuilder, error) {
-	_, adjusted, err := SimulateMsgs(txBldr, cliCtx, name, msgs, 0)
+	estimate, err := defaultGasEstimator.Estimate(txBldr, cliCtx, name, msgs)
 	if err != nil {
 		return txBldr, err
 	}
-	return txBldr.WithGas(adjusted), nil
+	return txBldr.WithGas(adjustGasEstimate(estimate, cliCtx.GasAdjustment)), nil
 }
 
 // CalculateGas simulates the execution of a transaction and returns
@@ -135,19 +253,70 @@ func CalculateGas(queryFunc func(string, common.HexBytes) ([]byte, error), cdc *
 	return
 }
 
-// PrintUnsignedStdTx builds an unsigned StdTx and prints it to os.Stdout.
-func PrintUnsignedStdTx(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, msgs []sdk.Msg) (err error) {
-	stdTx, err := buildUnsignedStdTx(txBldr, cliCtx, msgs)
+// PrintUnsignedStdTx builds an unsigned StdSignMsg and prints it to
+// os.Stdout as JSON. When offline is true, no node is contacted and the
+// chain-id, account number, sequence and gas already set on txBldr are
+// used as-is.
+func PrintUnsignedStdTx(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, msgs []sdk.Msg, offline bool) (err error) {
+	stdSignMsg, err := buildUnsignedStdTx(txBldr, cliCtx, msgs, offline)
 	if err != nil {
 		return
 	}
-	json, err := txBldr.Codec.MarshalJSON(stdTx)
+	json, err := txBldr.Codec.MarshalJSON(stdSignMsg)
 	if err == nil {
 		fmt.Printf("%s\n", json)
 	}
 	return
 }
 
+// SignStdTx signs stdTx with the named key. If appendSig is false, any
+// signatures already on stdTx are replaced rather than added to; multisig
+// co-signers must pass false so each produces a lone StdSignature that
+// MultiSignStdTx can merge. When offline is true, no node is contacted and
+// the account number and sequence already set on txBldr are used as-is.
+func SignStdTx(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, name string, stdTx auth.StdTx, appendSig, offline bool) (signedStdTx auth.StdTx, err error) {
+	txBldr, err = prepareTxBuilder(txBldr, cliCtx, offline)
+	if err != nil {
+		return
+	}
+
+	passphrase, err := keys.GetPassphrase(name)
+	if err != nil {
+		return
+	}
+
+	return txBldr.SignStdTx(name, passphrase, stdTx, appendSig)
+}
+
+// MultiSignStdTx merges sigs, each a lone StdSignature from one of
+// multisigPub's constituent keys over stdTx, into a single StdTx signed by
+// multisigPub.
+func MultiSignStdTx(stdTx auth.StdTx, multisigPub crkeys.Multi, sigs []auth.StdSignature) (signedStdTx auth.StdTx, err error) {
+	multisigSig := crkeys.NewMultiSig(multisigPub)
+	for _, sig := range sigs {
+		if err = multisigSig.AddSignature(stdTx, sig); err != nil {
+			return
+		}
+	}
+
+	newSig := auth.StdSignature{
+		PubKey:    multisigPub,
+		Signature: multisigSig.Marshal(),
+	}
+	signedStdTx = auth.NewStdTx(stdTx.Msgs, stdTx.Fee, []auth.StdSignature{newSig}, stdTx.Memo)
+	return
+}
+
+// BroadcastStdTx encodes a signed StdTx for the wire and broadcasts it to a
+// node.
+func BroadcastStdTx(cliCtx context.CLIContext, stdTx auth.StdTx) error {
+	txBytes, err := cliCtx.Codec.MarshalBinary(stdTx)
+	if err != nil {
+		return err
+	}
+	return cliCtx.EnsureBroadcastTx(txBytes)
+}
+
 func adjustGasEstimate(estimate int64, adjustment float64) int64 {
 	return int64(adjustment * float64(estimate))
 }
@@ -160,7 +329,11 @@ func parseQueryResponse(cdc *amino.Codec, rawRes []byte) (int64, error) {
 	return simulationResult.GasUsed, nil
 }
 
-func prepareTxBuilder(txBldr authtxb.TxBuilder, cliCtx context.CLIContext) (authtxb.TxBuilder, error) {
+func prepareTxBuilder(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, offline bool) (authtxb.TxBuilder, error) {
+	if offline {
+		return txBldr, nil
+	}
+
 	if err := cliCtx.EnsureAccountExists(); err != nil {
 		return txBldr, err
 	}
@@ -192,23 +365,22 @@ func prepareTxBuilder(txBldr authtxb.TxBuilder, cliCtx context.CLIContext) (auth
 	return txBldr, nil
 }
 
-// buildUnsignedStdTx builds a StdTx as per the parameters passed in the
-// contexts. Gas is automatically estimated if gas wanted is set to 0.
-func buildUnsignedStdTx(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, msgs []sdk.Msg) (stdTx auth.StdTx, err error) {
-	txBldr, err = prepareTxBuilder(txBldr, cliCtx)
+// buildUnsignedStdTx builds a StdSignMsg as per the parameters passed in the
+// contexts. Gas is automatically estimated if gas wanted is set to 0. When
+// offline is true, no node is contacted: the account lookup and gas
+// estimate are both skipped, and the chain-id, account number, sequence and
+// gas already set on txBldr are used as-is.
+func buildUnsignedStdTx(txBldr authtxb.TxBuilder, cliCtx context.CLIContext, msgs []sdk.Msg, offline bool) (stdSignMsg authtxb.StdSignMsg, err error) {
+	txBldr, err = prepareTxBuilder(txBldr, cliCtx, offline)
 	if err != nil {
 		return
 	}
-	if txBldr.Gas == 0 {
+	if !offline && txBldr.Gas == 0 {
 		txBldr, err = EnrichCtxWithGas(txBldr, cliCtx, cliCtx.FromAddressName, msgs)
 		if err != nil {
 			return
 		}
 		fmt.Fprintf(os.Stderr, "estimated gas = %v\n", txBldr.Gas)
 	}
-	stdSignMsg, err := txBldr.Build(msgs)
-	if err != nil {
-		return
-	}
-	return auth.NewStdTx(stdSignMsg.Msgs, stdSignMsg.Fee, nil, stdSignMsg.Memo), nil
+	return txBldr.Build(msgs)
 }
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	ckeys "github.com/cosmos/cosmos-sdk/client/keys"
+	crkeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	auth "github.com/cosmos/cosmos-sdk/x/auth"
+	authtxb "github.com/cosmos/cosmos-sdk/x/auth/client/txbuilder"
+	amino "github.com/tendermint/go-amino"
+	crypto "github.com/tendermint/go-crypto"
+)
+
+func TestAdjustGasEstimates(t *testing.T) {
+	estimates := []int64{100, 200, 300, 400, 500}
+
+	require.EqualValues(t, 500, AdjustGasEstimates(estimates, 1.0, GasPolicyMax, 0))
+	require.EqualValues(t, 100, AdjustGasEstimates(estimates, 1.0, GasPolicyMin, 0))
+	require.EqualValues(t, 300, AdjustGasEstimates(estimates, 1.0, GasPolicyPercentile, 50))
+	require.EqualValues(t, 750, AdjustGasEstimates(estimates, 1.5, GasPolicyMax, 0))
+	require.EqualValues(t, 0, AdjustGasEstimates(nil, 1.0, GasPolicyMax, 0))
+}
+
+// withPassphrase redirects os.Stdin for the duration of fn so that a
+// keys.GetPassphrase prompt triggered inside fn reads passphrase instead of
+// blocking on the real terminal.
+func withPassphrase(t *testing.T, passphrase string, fn func()) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+
+	oldStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = oldStdin }()
+
+	go func() {
+		io.WriteString(w, passphrase+"\n")
+		w.Close()
+	}()
+
+	fn()
+}
+
+// TestSignAndMultiSignStdTx drives the full offline multisig ceremony: each
+// co-signer calls SignStdTx with appendSig=false to produce its own lone
+// StdSignature, and MultiSignStdTx merges the two into a single signature
+// under the multisig pubkey.
+func TestSignAndMultiSignStdTx(t *testing.T) {
+	viper.Set("home", t.TempDir())
+	kb, err := ckeys.GetKeyBaseWithWritePerm()
+	require.NoError(t, err)
+
+	const passphrase = "test-passphrase"
+	infoA, _, err := kb.CreateMnemonic("cosigner-a", crkeys.English, passphrase, crkeys.SigningAlgo("secp256k1"))
+	require.NoError(t, err)
+	infoB, _, err := kb.CreateMnemonic("cosigner-b", crkeys.English, passphrase, crkeys.SigningAlgo("secp256k1"))
+	require.NoError(t, err)
+
+	multisigPub := crkeys.NewMultiSigPubKey(2, []crypto.PubKey{infoA.GetPubKey(), infoB.GetPubKey()})
+
+	cdc := amino.NewCodec()
+	txBldr := authtxb.TxBuilder{Codec: cdc}.WithChainID("test-chain").WithAccountNumber(0).WithSequence(0)
+	stdTx := auth.NewStdTx(nil, auth.StdFee{}, nil, "multisig round trip")
+
+	var sigs []auth.StdSignature
+	for _, name := range []string{"cosigner-a", "cosigner-b"} {
+		var signed auth.StdTx
+		withPassphrase(t, passphrase, func() {
+			signed, err = SignStdTx(txBldr, context.CLIContext{}, name, stdTx, false, true)
+		})
+		require.NoError(t, err)
+		require.Len(t, signed.Signatures, 1, "appendSig=false must replace, not accumulate, signatures")
+		sigs = append(sigs, signed.Signatures[0])
+	}
+
+	signed, err := MultiSignStdTx(stdTx, multisigPub, sigs)
+	require.NoError(t, err)
+	require.Len(t, signed.Signatures, 1)
+	require.Equal(t, multisigPub, signed.Signatures[0].PubKey)
+}